@@ -2,35 +2,81 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	ginzap "github.com/gin-contrib/zap"
 	"github.com/gin-gonic/gin"
 	"github.com/go-ping/ping"
+	"github.com/juju/ratelimit"
+	"github.com/robfig/cron/v3"
 	"go.uber.org/zap"
+
+	"github.com/2096779623/SourceForgeSpeedTest/mget"
+	"github.com/2096779623/SourceForgeSpeedTest/mirror"
 )
 
 var (
-	logger              *zap.Logger
-	version             bool
-	threads             int
-	file                string
-	pingcount           int
-	pingtimeout         int
-	port                string
-	multifastestdomain  string
-	singlefastestdomain string
-	allfastestdomain    string
+	logger      *zap.Logger
+	version     bool
+	file        string
+	pingcount   int
+	pingtimeout int
+	port        string
+	schedule    string
+	source      string
+
+	testURL      string
+	dlTime       int
+	dlSize       int64
+	minSpeedMBs  float64
+	maxLatencyMs int
+	minLatencyMs int
+
+	segments   int
+	segRetries int
+
+	globalBps int64
+	perIPBps  int64
+	burst     int64
+	rps       float64
+	idleTTL   int
+
+	dlWorkDirTTL int
+
+	globalBucket *ratelimit.Bucket
+	rpsBucket    *ratelimit.Bucket
+	ipBuckets    sync.Map // map[string]*ipBucketEntry
+
+	healthMu    sync.RWMutex
+	healthState = map[string][]Domain{}
+
+	currentState atomic.Value // 保存 *DomainState，供 Gin 处理函数无锁读取
+	stats        = newStats()
 )
 
+// ipBucketEntry 记录某个来源 IP 的限速桶及其最近一次使用时间，供空闲淘汰使用
+type ipBucketEntry struct {
+	bucket   *ratelimit.Bucket
+	lastUsed time.Time
+}
+
 func initLogger() {
 	logger, _ = zap.NewProduction()
 	defer logger.Sync()
@@ -39,15 +85,421 @@ func initLogger() {
 type Domain struct {
 	Name        string
 	Latency     int
-	Download    int
+	Download    int // 单位 KB/s
 	DownloadErr bool
+	Cert        *CertInfo `json:",omitempty"`
+}
+
+// CertInfo 记录一个域名的 TLS 证书到期信息
+type CertInfo struct {
+	Issuer   string
+	NotAfter time.Time
+	DaysLeft int
+	SANs     []string
+}
+
+// certExpiryWarnDays 是最快节点证书剩余天数的预警阈值
+const certExpiryWarnDays = 14
+
+// DomainState 是某一轮测速的完整快照，通过 atomic.Value 整体替换，Gin 处理函数无锁读取
+type DomainState struct {
+	AllDomains    []Domain
+	MultiDomains  []Domain
+	SingleDomains []Domain
+	AllFastest    string
+	MultiFastest  string
+	SingleFastest string
+	UpdatedAt     time.Time
+}
+
+// loadState 读取当前最新的测速快照；在首轮测速完成前返回 nil
+func loadState() *DomainState {
+	v := currentState.Load()
+	if v == nil {
+		return nil
+	}
+	return v.(*DomainState)
+}
+
+// Scheduler 按 cron 表达式周期性地触发测速轮次，并将结果发布到 results 通道
+type Scheduler struct {
+	cron     *cron.Cron
+	schedule string
+	sources  []mirror.MirrorSource
+	results  chan *DomainState
+}
+
+// NewScheduler 创建一个尚未启动的 Scheduler，sources 描述每轮测速前如何获取候选域名
+func NewScheduler(schedule string, sources []mirror.MirrorSource) *Scheduler {
+	return &Scheduler{
+		cron:     cron.New(),
+		schedule: schedule,
+		sources:  sources,
+		results:  make(chan *DomainState, 1),
+	}
+}
+
+// Results 返回只读的结果通道，每轮测速完成后发布一个 *DomainState
+func (s *Scheduler) Results() <-chan *DomainState {
+	return s.results
+}
+
+// Start 立即执行一轮测速，然后按 schedule 注册后续轮次并启动 cron
+func (s *Scheduler) Start() {
+	round := func() {
+		s.results <- measureRound(s.sources)
+	}
+	round()
+
+	if _, err := s.cron.AddFunc(s.schedule, round); err != nil {
+		logger.Error("无法解析测速计划：", zap.String("schedule", s.schedule), zap.Error(err))
+		return
+	}
+	s.cron.Start()
+}
+
+// refreshDomainSet 测速 filename 对应的域名集；读取失败（文件缺失/格式非法等瞬时错误）
+// 时不中断整轮测速，而是沿用 prev 快照中的对应结果，保持服务可用性
+func refreshDomainSet(filename string, prev *DomainState, pick func(*DomainState) ([]Domain, string)) ([]Domain, string, error) {
+	domains, err := findFastestDomainSet(filename)
+	if err != nil {
+		if prev != nil {
+			domains, fastest := pick(prev)
+			return domains, fastest, err
+		}
+		return nil, "", err
+	}
+	fastest := ""
+	if len(domains) > 0 {
+		fastest = domains[0].Name
+	}
+	return domains, fastest, nil
 }
 
-type ByLatency []Domain
+// measureRound 从配置的数据源获取候选域名，执行一整轮延迟/带宽/证书测速，返回独立的状态快照
+func measureRound(sources []mirror.MirrorSource) *DomainState {
+	hosts, err := mirror.FetchAll(context.Background(), sources)
+	if err != nil {
+		logger.Error("获取镜像域名列表失败：", zap.Error(err))
+	}
+
+	all := make([]Domain, len(hosts))
+	for i, host := range hosts {
+		all[i] = Domain{Name: host}
+	}
 
-func (a ByLatency) Len() int           { return len(a) }
-func (a ByLatency) Less(i, j int) bool { return a[i].Latency < a[j].Latency }
-func (a ByLatency) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+	measureLatencyAndDownload(&all)
+	updateDomainsCert(&all)
+
+	filtered := make([]Domain, 0, len(all))
+	for _, d := range all {
+		if passesThresholds(d) {
+			filtered = append(filtered, d)
+		}
+	}
+	if len(filtered) == 0 && len(all) > 0 {
+		logger.Warn("没有节点满足延迟/速度阈值，回退为未过滤的测速结果")
+		filtered = all
+	}
+	sortDomainsByScore(filtered)
+
+	prev := loadState()
+	multiDomains, multiFastest, err := refreshDomainSet("multi.txt", prev, func(s *DomainState) ([]Domain, string) {
+		return s.MultiDomains, s.MultiFastest
+	})
+	if err != nil {
+		logger.Error("刷新 multi 域名集失败，沿用上一轮结果：", zap.Error(err))
+	}
+	singleDomains, singleFastest, err := refreshDomainSet("single.txt", prev, func(s *DomainState) ([]Domain, string) {
+		return s.SingleDomains, s.SingleFastest
+	})
+	if err != nil {
+		logger.Error("刷新 single 域名集失败，沿用上一轮结果：", zap.Error(err))
+	}
+
+	state := &DomainState{
+		AllDomains:    filtered,
+		MultiDomains:  multiDomains,
+		SingleDomains: singleDomains,
+		MultiFastest:  multiFastest,
+		SingleFastest: singleFastest,
+		UpdatedAt:     time.Now(),
+	}
+	if len(filtered) > 0 {
+		state.AllFastest = filtered[0].Name
+	} else {
+		logger.Error("镜像数据源未返回任何域名，无法确定最快节点")
+	}
+
+	storeHealthState("all", state.AllDomains)
+	storeHealthState("multi", state.MultiDomains)
+	storeHealthState("single", state.SingleDomains)
+
+	warnIfExpiringSoon("all", state.AllFastest, state.AllDomains)
+	warnIfExpiringSoon("multi", state.MultiFastest, state.MultiDomains)
+	warnIfExpiringSoon("single", state.SingleFastest, state.SingleDomains)
+
+	stats.recordRound(state.AllDomains)
+
+	return state
+}
+
+// Stats 汇总测速轮次、各域名成败次数、最近延迟/带宽以及各路由的请求与转发字节数
+type Stats struct {
+	mu sync.Mutex
+
+	rounds           int64
+	domainSuccess    map[string]int64
+	domainFailure    map[string]int64
+	domainLatency    map[string]int
+	domainThroughput map[string]int
+	requestCounts    map[string]int64
+	bytesProxied     int64
+}
+
+func newStats() *Stats {
+	return &Stats{
+		domainSuccess:    make(map[string]int64),
+		domainFailure:    make(map[string]int64),
+		domainLatency:    make(map[string]int),
+		domainThroughput: make(map[string]int),
+		requestCounts:    make(map[string]int64),
+	}
+}
+
+// recordRound 汇总一轮测速中每个域名的成败次数及最近一次的延迟/带宽
+func (s *Stats) recordRound(domains []Domain) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rounds++
+	for _, d := range domains {
+		if d.DownloadErr || d.Latency == -1 {
+			s.domainFailure[d.Name]++
+			continue
+		}
+		s.domainSuccess[d.Name]++
+		s.domainLatency[d.Name] = d.Latency
+		s.domainThroughput[d.Name] = d.Download
+	}
+}
+
+// recordRequest 记录某条路由被访问的次数
+func (s *Stats) recordRequest(route string) {
+	s.mu.Lock()
+	s.requestCounts[route]++
+	s.mu.Unlock()
+}
+
+// addBytesProxied 累加 /dl 代理路径转发给客户端的字节数
+func (s *Stats) addBytesProxied(n int64) {
+	atomic.AddInt64(&s.bytesProxied, n)
+}
+
+// snapshot 返回可直接序列化为 JSON 的统计快照
+func (s *Stats) snapshot() gin.H {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return gin.H{
+		"rounds":            s.rounds,
+		"domain_success":    s.domainSuccess,
+		"domain_failure":    s.domainFailure,
+		"domain_latency_ms": s.domainLatency,
+		"domain_kbps":       s.domainThroughput,
+		"request_counts":    s.requestCounts,
+		"bytes_proxied":     atomic.LoadInt64(&s.bytesProxied),
+	}
+}
+
+// prometheus 以 Prometheus 文本格式输出统计数据
+func (s *Stats) prometheus() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP sfst_rounds_total 已完成的测速轮次\n# TYPE sfst_rounds_total counter\nsfst_rounds_total %d\n", s.rounds)
+	fmt.Fprintf(&b, "# HELP sfst_bytes_proxied_total 通过 /dl 代理转发的总字节数\n# TYPE sfst_bytes_proxied_total counter\nsfst_bytes_proxied_total %d\n", atomic.LoadInt64(&s.bytesProxied))
+
+	fmt.Fprintf(&b, "# HELP sfst_requests_total 各路由的请求次数\n# TYPE sfst_requests_total counter\n")
+	for route, count := range s.requestCounts {
+		fmt.Fprintf(&b, "sfst_requests_total{route=%q} %d\n", route, count)
+	}
+
+	fmt.Fprintf(&b, "# HELP sfst_domain_success_total 域名测速成功次数\n# TYPE sfst_domain_success_total counter\n")
+	for domain, count := range s.domainSuccess {
+		fmt.Fprintf(&b, "sfst_domain_success_total{domain=%q} %d\n", domain, count)
+	}
+
+	fmt.Fprintf(&b, "# HELP sfst_domain_failure_total 域名测速失败次数\n# TYPE sfst_domain_failure_total counter\n")
+	for domain, count := range s.domainFailure {
+		fmt.Fprintf(&b, "sfst_domain_failure_total{domain=%q} %d\n", domain, count)
+	}
+
+	fmt.Fprintf(&b, "# HELP sfst_domain_latency_ms 域名最近一次测得的延迟\n# TYPE sfst_domain_latency_ms gauge\n")
+	for domain, latency := range s.domainLatency {
+		fmt.Fprintf(&b, "sfst_domain_latency_ms{domain=%q} %d\n", domain, latency)
+	}
+
+	fmt.Fprintf(&b, "# HELP sfst_domain_throughput_kbps 域名最近一次测得的下载速度\n# TYPE sfst_domain_throughput_kbps gauge\n")
+	for domain, kbps := range s.domainThroughput {
+		fmt.Fprintf(&b, "sfst_domain_throughput_kbps{domain=%q} %d\n", domain, kbps)
+	}
+
+	return b.String()
+}
+
+const (
+	latencyWeight    = 0.4
+	throughputWeight = 0.6
+)
+
+// sortDomainsByScore 按延迟与下载速度的加权得分从高到低排序。
+// 延迟和下载速度量纲相差悬殊（延迟是毫秒级的小数字，下载速度常是几百上千 KB/s），
+// 因此先在当前集合内做 min-max 归一化，再加权，避免权重被量纲差异淹没。
+func sortDomainsByScore(domains []Domain) {
+	if len(domains) < 2 {
+		return
+	}
+
+	minLatency, maxLatency := domains[0].Latency, domains[0].Latency
+	minThroughput, maxThroughput := domains[0].Download, domains[0].Download
+	for _, d := range domains[1:] {
+		if d.Latency < minLatency {
+			minLatency = d.Latency
+		}
+		if d.Latency > maxLatency {
+			maxLatency = d.Latency
+		}
+		if d.Download < minThroughput {
+			minThroughput = d.Download
+		}
+		if d.Download > maxThroughput {
+			maxThroughput = d.Download
+		}
+	}
+
+	score := func(d Domain) float64 {
+		latencyScore := 1.0
+		if maxLatency > minLatency {
+			// 延迟越低越好，归一化后取反向比例
+			latencyScore = float64(maxLatency-d.Latency) / float64(maxLatency-minLatency)
+		}
+		throughputScore := 1.0
+		if maxThroughput > minThroughput {
+			throughputScore = float64(d.Download-minThroughput) / float64(maxThroughput-minThroughput)
+		}
+		return latencyWeight*latencyScore + throughputWeight*throughputScore
+	}
+
+	sort.Slice(domains, func(i, j int) bool { return score(domains[i]) > score(domains[j]) })
+}
+
+// passesThresholds 判断节点是否满足延迟与速度的筛选条件
+func passesThresholds(d Domain) bool {
+	if d.DownloadErr || d.Latency == -1 {
+		return false
+	}
+	if maxLatencyMs > 0 && d.Latency > maxLatencyMs {
+		return false
+	}
+	if minLatencyMs > 0 && d.Latency < minLatencyMs {
+		return false
+	}
+	if minSpeedMBs > 0 && float64(d.Download)/1024 < minSpeedMBs {
+		return false
+	}
+	return true
+}
+
+// SpeedTester 通过分段 Range 请求测量真实带宽
+type SpeedTester struct {
+	URL    string
+	DLTime int
+	DLSize int64
+	Client *http.Client
+}
+
+func newSpeedTester() *SpeedTester {
+	return &SpeedTester{
+		URL:    testURL,
+		DLTime: dlTime,
+		DLSize: dlSize,
+		Client: &http.Client{},
+	}
+}
+
+type byteCounter struct {
+	mu sync.Mutex
+	n  int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	c.n += int64(len(p))
+	c.mu.Unlock()
+	return len(p), nil
+}
+
+func (c *byteCounter) bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
+
+// Test 对指定域名发起带 Range 头的 GET 请求，按字节计数估算 KB/s
+func (st *SpeedTester) Test(domain string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(st.DLTime)*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+domain+st.URL, nil)
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Range", "bytes=0-")
+
+	resp, err := st.Client.Do(req)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+
+	var body io.Reader = resp.Body
+	if st.DLSize > 0 {
+		body = io.LimitReader(resp.Body, st.DLSize)
+	}
+
+	counter := &byteCounter{}
+	start := time.Now()
+	copyDone := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(counter, body)
+		copyDone <- err
+	}()
+
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case err := <-copyDone:
+			if err != nil && err != io.EOF {
+				return -1, err
+			}
+			break loop
+		case <-ticker.C:
+		}
+	}
+
+	elapsed := time.Since(start).Seconds()
+	read := counter.bytes()
+	if elapsed <= 0 || read == 0 {
+		return -1, fmt.Errorf("测速失败：未读取到任何数据")
+	}
+
+	return int(float64(read) / elapsed / 1024), nil
+}
 
 func main() {
 	initLogger()
@@ -55,57 +507,67 @@ func main() {
 
 	flag.StringVar(&file, "file", "all.txt", "要读取的域名列表文件")
 	flag.BoolVar(&version, "v", false, "输出版本信息")
-	flag.IntVar(&threads, "threads", 32, "指定下载测速的线程数量")
 	flag.IntVar(&pingcount, "c", 1, "每次 ping 的包次数")
 	flag.IntVar(&pingtimeout, "timeout", 1, "ping 的超时时间")
 	flag.StringVar(&port, "port", "1340", "端口")
+	flag.StringVar(&testURL, "url", "/project/sevenzip/files/7-Zip/23.01/7zr.exe", "用于测速的测试文件路径")
+	flag.IntVar(&dlTime, "dlTime", 5, "单次测速最长持续时间（秒）")
+	flag.Int64Var(&dlSize, "dlSize", 20*1024*1024, "单次测速最多读取的字节数")
+	flag.Float64Var(&minSpeedMBs, "sl", 0, "保留节点所需的最低下载速度（MB/s），0 表示不限制")
+	flag.IntVar(&maxLatencyMs, "tl", 0, "保留节点所允许的最大延迟（毫秒），0 表示不限制")
+	flag.IntVar(&minLatencyMs, "tll", 0, "保留节点所允许的最小延迟（毫秒），0 表示不限制")
+	flag.IntVar(&segments, "segments", 4, "/dl 代理下载时的并行分段数")
+	flag.IntVar(&segRetries, "segRetries", 3, "/dl 代理下载单个分段失败后的最大重试次数")
+	flag.Int64Var(&globalBps, "globalBps", 0, "/dl 代理下载的全局带宽上限（字节/秒），0 表示不限制")
+	flag.Int64Var(&perIPBps, "perIPBps", 0, "/dl 代理下载的单 IP 带宽上限（字节/秒），0 表示不限制")
+	flag.Int64Var(&burst, "burst", 0, "带宽限速的突发容量（字节），0 表示等于对应速率")
+	flag.Float64Var(&rps, "rps", 0, "重定向路由的每秒请求数上限，0 表示不限制")
+	flag.IntVar(&idleTTL, "idleTTL", 600, "单 IP 限速桶的空闲回收时间（秒）")
+	flag.IntVar(&dlWorkDirTTL, "dlWorkDirTTL", 3600, "/dl 工作目录的陈旧回收时间（秒），用于清理失败或中止下载遗留的分段文件，0 表示不清理")
+	flag.StringVar(&schedule, "schedule", "@every 10m", "测速轮询计划（robfig/cron 语法，如 \"@every 10m\"）")
+	flag.StringVar(&source, "source", "", "逗号分隔的镜像数据源列表，支持 file://、http(s)://、sf:// 前缀；留空时默认使用 -file 指定的文件")
 	flag.Parse()
 
+	if globalBps > 0 {
+		globalBucket = ratelimit.NewBucketWithRate(float64(globalBps), bucketCapacity(globalBps))
+	}
+	if rps > 0 {
+		rpsBucket = ratelimit.NewBucketWithRate(rps, int64(rps)+1)
+	}
+	startIdleBucketEviction()
+	mget.StartStaleWorkDirSweeper(mgetDlBaseDir, time.Duration(dlWorkDirTTL)*time.Second, time.Duration(dlWorkDirTTL)*time.Second)
+
 	if version {
 		fmt.Print("1.0.0")
 		os.Exit(0)
 	}
 
-	domains := readDomainsFromFile(file)
-	updateAndStoreFastestDomains(&domains)
-
-	// 启动 Gin 服务器时直接使用当前最低延迟的域名
-	startGinServer()
-
-	// 创建定时器，每隔 10 分钟执行一次测速并更新 domain
-	ticker := time.NewTicker(10 * time.Minute)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		updateAndStoreFastestDomains(&domains)
-		startGinServer()
+	sourceSpec := source
+	if sourceSpec == "" {
+		sourceSpec = "file://" + file
+	}
+	sources, err := mirror.ParseSources(sourceSpec)
+	if err != nil {
+		logger.Error("无法解析镜像数据源：", zap.String("source", sourceSpec), zap.Error(err))
+		os.Exit(1)
 	}
-}
-
-func updateAndStoreFastestDomains(domains *[]Domain) {
-	updateDomainsLatency(domains)
-	sort.Sort(ByLatency(*domains))
 
-	// 更新当前最低延迟的域名并存储
-	allfastestdomain = (*domains)[0].Name
-	multifastestdomain = findFastestDomain("multi.txt")
-	singlefastestdomain = findFastestDomain("single.txt")
-}
+	scheduler := NewScheduler(schedule, sources)
+	go func() {
+		for state := range scheduler.Results() {
+			currentState.Store(state)
+		}
+	}()
+	// 首轮测速同步完成后再启动 Gin；状态由上面的协程写入 currentState，写入前到达的请求返回 503
+	scheduler.Start()
 
-func updateDomainsLatency(domains *[]Domain) {
-	wg := sync.WaitGroup{}
-	for i := range *domains {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
-			latency, _ := tping((*domains)[i].Name)
-			(*domains)[i].Latency = latency
-		}(i)
-	}
-	wg.Wait()
+	// r.Run 只调用一次；后续每轮测速通过 atomic.Value 热替换状态，Gin 不再重启
+	startGinServer()
 }
 
 func measureLatencyAndDownload(domains *[]Domain) {
+	tester := newSpeedTester()
+
 	var wg sync.WaitGroup
 	for i := range *domains {
 		wg.Add(1)
@@ -114,7 +576,7 @@ func measureLatencyAndDownload(domains *[]Domain) {
 			latency, _ := tping((*domains)[i].Name)
 			(*domains)[i].Latency = latency
 			if latency != -1 {
-				downloadSpeed, err := download((*domains)[i].Name)
+				downloadSpeed, err := tester.Test((*domains)[i].Name)
 				if err != nil {
 					logger.Error("无法下载：", zap.Error(err))
 					(*domains)[i].DownloadErr = true
@@ -129,6 +591,68 @@ func measureLatencyAndDownload(domains *[]Domain) {
 	excludeDownloadError(domains)
 }
 
+// updateDomainsCert 并行探测每个域名的 TLS 证书到期信息
+func updateDomainsCert(domains *[]Domain) {
+	var wg sync.WaitGroup
+	for i := range *domains {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cert, err := probeCert((*domains)[i].Name)
+			if err != nil {
+				logger.Warn("无法探测证书信息：", zap.String("domain", (*domains)[i].Name), zap.Error(err))
+				return
+			}
+			(*domains)[i].Cert = cert
+		}(i)
+	}
+	wg.Wait()
+}
+
+// probeCert 与域名建立 TLS 连接并读取其证书的到期时间和 SAN 列表
+func probeCert(domain string) (*CertInfo, error) {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", domain+":443", &tls.Config{ServerName: domain})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("未获取到对端证书")
+	}
+	cert := state.PeerCertificates[0]
+
+	return &CertInfo{
+		Issuer:   cert.Issuer.CommonName,
+		NotAfter: cert.NotAfter,
+		DaysLeft: int(time.Until(cert.NotAfter).Hours() / 24),
+		SANs:     cert.DNSNames,
+	}, nil
+}
+
+// storeHealthState 记录某一组域名（all/single/multi）的最新测速与证书结果，供 /health 读取
+func storeHealthState(label string, domains []Domain) {
+	healthMu.Lock()
+	healthState[label] = domains
+	healthMu.Unlock()
+}
+
+// warnIfExpiringSoon 当某组被选中的最快节点证书即将到期时输出告警日志
+func warnIfExpiringSoon(label, domainName string, domains []Domain) {
+	for _, d := range domains {
+		if d.Name != domainName || d.Cert == nil {
+			continue
+		}
+		if d.Cert.DaysLeft <= certExpiryWarnDays {
+			logger.Warn("所选最快节点证书即将到期",
+				zap.String("group", label),
+				zap.String("domain", domainName),
+				zap.Int("days_left", d.Cert.DaysLeft))
+		}
+	}
+}
+
 func excludeDownloadError(domains *[]Domain) {
 	var filtered []Domain
 	for _, d := range *domains {
@@ -157,62 +681,23 @@ func tping(domain string) (int, error) {
 		return -1, err
 	}
 
-	stats := pinger.Statistics()
-	if stats.PacketLoss > 0 {
-		logger.Warn("检测到丢包", zap.Float64("packet_loss", stats.PacketLoss))
+	pingStats := pinger.Statistics()
+	if pingStats.PacketLoss > 0 {
+		logger.Warn("检测到丢包", zap.Float64("packet_loss", pingStats.PacketLoss))
 		return -1, fmt.Errorf("检测到丢包")
 	}
 
-	return int(stats.AvgRtt.Milliseconds()), nil
-}
-func download(domain string) (int, error) {
-	var wg sync.WaitGroup
-	speedCh := make(chan int)
-
-	for i := 0; i < threads; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			start := time.Now()
-			resp, err := http.Get("https://" + domain + "/project/sevenzip/files/7-Zip/23.01/7zr.exe?viasf=1")
-			if err != nil {
-				logger.Error("无法下载：", zap.Error(err))
-				speedCh <- -1
-				return
-			}
-			defer resp.Body.Close()
-			elapsed := time.Since(start).Milliseconds()
-			speedCh <- int(elapsed)
-		}()
-	}
-
-	go func() {
-		wg.Wait()
-		close(speedCh)
-	}()
-
-	var totalSpeed int
-	var count int
-	for speed := range speedCh {
-		if speed != -1 {
-			totalSpeed += speed
-			count++
-		}
-	}
-
-	if count == 0 {
-		return -1, fmt.Errorf("节点状态异常！")
-	}
-
-	return totalSpeed / count, nil
+	return int(pingStats.AvgRtt.Milliseconds()), nil
 }
 
-func readDomainsFromFile(filename string) []Domain {
+// readDomainsFromFile 按行读取域名列表文件；文件缺失或格式非法时返回 error 而不是
+// 终止进程——multi/single 域名集在 measureRound 中按计划周期性刷新，一次性的读取
+// 失败不应该连累整个长期运行的服务（包括 /health、/metrics、/dl 等与该文件无关的路由）
+func readDomainsFromFile(filename string) ([]Domain, error) {
 	var domains []Domain
 	file, err := os.Open(filename)
 	if err != nil {
-		logger.Error("无法读取文件：", zap.String("filename", filename), zap.Error(err))
-		os.Exit(1)
+		return nil, fmt.Errorf("无法读取文件 %s: %w", filename, err)
 	}
 	defer file.Close()
 
@@ -221,9 +706,9 @@ func readDomainsFromFile(filename string) []Domain {
 		domains = append(domains, Domain{Name: scanner.Text()})
 	}
 	if err := scanner.Err(); err != nil {
-		logger.Error("文件格式非法：", zap.Error(err))
+		return nil, fmt.Errorf("文件 %s 格式非法: %w", filename, err)
 	}
-	return domains
+	return domains, nil
 }
 
 func startGinServer() {
@@ -231,38 +716,251 @@ func startGinServer() {
 	r.Use(ginzap.Ginzap(logger, time.RFC3339, true))
 
 	// /all/*path 路由
-	r.GET("/all/*path", func(c *gin.Context) {
+	r.GET("/all/*path", rpsLimitMiddleware(), requestCounterMiddleware("all"), func(c *gin.Context) {
+		state := loadState()
+		if state == nil {
+			c.Status(http.StatusServiceUnavailable)
+			return
+		}
 		_, originalPath := extractDomainAndPath(c.Param("path"))
-		redirectURI := buildRedirectURI(originalPath, allfastestdomain)
+		redirectURI := buildRedirectURI(originalPath, state.AllFastest)
 		c.Redirect(http.StatusMovedPermanently, redirectURI)
 	})
 
 	// /single/*path 路由
-	r.GET("/single/*path", func(c *gin.Context) {
+	r.GET("/single/*path", rpsLimitMiddleware(), requestCounterMiddleware("single"), func(c *gin.Context) {
+		state := loadState()
+		if state == nil {
+			c.Status(http.StatusServiceUnavailable)
+			return
+		}
 		_, originalPath := extractDomainAndPath(c.Param("path"))
-		redirectURI := buildRedirectURI(originalPath, singlefastestdomain)
+		redirectURI := buildRedirectURI(originalPath, state.SingleFastest)
 		c.Redirect(http.StatusMovedPermanently, redirectURI)
 	})
 
 	// /multi/*path 路由
-	r.GET("/multi/*path", func(c *gin.Context) {
+	r.GET("/multi/*path", rpsLimitMiddleware(), requestCounterMiddleware("multi"), func(c *gin.Context) {
+		state := loadState()
+		if state == nil {
+			c.Status(http.StatusServiceUnavailable)
+			return
+		}
 		_, originalPath := extractDomainAndPath(c.Param("path"))
-		redirectURI := buildRedirectURI(originalPath, multifastestdomain)
+		redirectURI := buildRedirectURI(originalPath, state.MultiFastest)
 		c.Redirect(http.StatusMovedPermanently, redirectURI)
 	})
 
+	// /dl/*path 路由，以分段并发的方式代理下载，响应带上游的 Content-Length 和 Accept-Ranges: bytes；
+	// 客户端请求中的 Range 头不被处理，始终以 200 返回完整文件
+	r.GET("/dl/*path", requestCounterMiddleware("dl"), func(c *gin.Context) {
+		state := loadState()
+		if state == nil {
+			c.Status(http.StatusServiceUnavailable)
+			return
+		}
+		_, originalPath := extractDomainAndPath(c.Param("path"))
+		upstreamURL := buildRedirectURI(originalPath, state.AllFastest)
+
+		downloader := mget.NewDownloader(upstreamURL, segments, segRetries, mgetWorkDir(upstreamURL))
+
+		ctx := c.Request.Context()
+		size, err := downloader.Size(ctx)
+		if err != nil {
+			logger.Error("无法获取上游文件信息：", zap.Error(err))
+			c.Status(http.StatusBadGateway)
+			return
+		}
+
+		c.Header("Accept-Ranges", "bytes")
+		if size > 0 {
+			c.Header("Content-Length", strconv.FormatInt(size, 10))
+		}
+		c.Status(http.StatusOK)
+
+		var out io.Writer = &countingWriter{w: c.Writer}
+		if globalBucket != nil {
+			out = ratelimit.Writer(out, globalBucket)
+		}
+		if ipBucket := getIPBucket(c.ClientIP()); ipBucket != nil {
+			out = ratelimit.Writer(out, ipBucket)
+		}
+
+		if err := downloader.Download(ctx, out); err != nil {
+			logger.Error("代理下载失败：", zap.Error(err))
+		}
+	})
+
+	// /stats 路由，输出测速轮次、域名成败、延迟/带宽及路由请求统计
+	r.GET("/stats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, stats.snapshot())
+	})
+
+	// /metrics 路由，以 Prometheus 文本格式输出统计数据及限速桶的可用容量
+	r.GET("/metrics", func(c *gin.Context) {
+		var b strings.Builder
+		b.WriteString(stats.prometheus())
+
+		if globalBucket != nil {
+			fmt.Fprintf(&b, "sfst_bucket_available_bps{scope=\"global\"} %d\n", globalBucket.Available())
+			fmt.Fprintf(&b, "sfst_bucket_capacity_bps{scope=\"global\"} %d\n", globalBucket.Capacity())
+		}
+		if rpsBucket != nil {
+			fmt.Fprintf(&b, "sfst_bucket_available_bps{scope=\"rps\"} %d\n", rpsBucket.Available())
+			fmt.Fprintf(&b, "sfst_bucket_capacity_bps{scope=\"rps\"} %d\n", rpsBucket.Capacity())
+		}
+		ipBuckets.Range(func(key, value interface{}) bool {
+			entry := value.(*ipBucketEntry)
+			fmt.Fprintf(&b, "sfst_bucket_available_bps{scope=\"ip\",ip=%q} %d\n", key.(string), entry.bucket.Available())
+			fmt.Fprintf(&b, "sfst_bucket_capacity_bps{scope=\"ip\",ip=%q} %d\n", key.(string), entry.bucket.Capacity())
+			return true
+		})
+
+		c.Data(http.StatusOK, "text/plain; version=0.0.4", []byte(b.String()))
+	})
+
+	// /health 路由，返回各组域名的延迟、带宽与证书信息
+	r.GET("/health", func(c *gin.Context) {
+		healthMu.RLock()
+		defer healthMu.RUnlock()
+		c.JSON(http.StatusOK, healthState)
+	})
+
+	// /health/expiring 路由，列出证书将在指定天数内到期的域名
+	r.GET("/health/expiring", func(c *gin.Context) {
+		days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days 参数非法"})
+			return
+		}
+
+		healthMu.RLock()
+		defer healthMu.RUnlock()
+
+		expiring := make(map[string][]Domain)
+		for label, domains := range healthState {
+			for _, d := range domains {
+				if d.Cert != nil && d.Cert.DaysLeft <= days {
+					expiring[label] = append(expiring[label], d)
+				}
+			}
+		}
+		c.JSON(http.StatusOK, expiring)
+	})
+
 	err := r.Run(":" + port)
 	if err != nil {
 		logger.Error("Web服务启动失败：", zap.Error(err))
 	}
 }
 
-// 从文件中读取域名列表并进行测速，返回延迟最短的域名
-func findFastestDomain(filename string) string {
-	domains := readDomainsFromFile(filename)
+// mgetDlBaseDir 是所有 /dl 工作目录的公共根目录，供启动时开启的周期性陈旧目录清理使用
+var mgetDlBaseDir = filepath.Join(os.TempDir(), "mget")
+
+// mgetWorkDir 按上游 URL 的哈希值生成工作目录，用于存放分段文件和 .mget.json 断点续传进度。
+// 同一 URL 的并发请求会映射到同一目录；mget.Downloader 内部通过互斥锁串行化对该目录的访问，
+// 既避免并发请求互相破坏分段文件，又能让后发请求复用先前请求留下的下载进度。
+func mgetWorkDir(upstreamURL string) string {
+	sum := sha1.Sum([]byte(upstreamURL))
+	return filepath.Join(mgetDlBaseDir, hex.EncodeToString(sum[:]))
+}
+
+// bucketCapacity 在未显式指定 -burst 时，令桶容量退化为速率本身
+func bucketCapacity(rate int64) int64 {
+	if burst > 0 {
+		return burst
+	}
+	return rate
+}
+
+// getIPBucket 惰性创建并返回指定 IP 的限速桶，perIPBps 为 0 时不做限制
+func getIPBucket(ip string) *ratelimit.Bucket {
+	if perIPBps <= 0 {
+		return nil
+	}
+	v, _ := ipBuckets.LoadOrStore(ip, &ipBucketEntry{
+		bucket:   ratelimit.NewBucketWithRate(float64(perIPBps), bucketCapacity(perIPBps)),
+		lastUsed: time.Now(),
+	})
+	entry := v.(*ipBucketEntry)
+	entry.lastUsed = time.Now()
+	return entry.bucket
+}
+
+// startIdleBucketEviction 定期清理长时间未使用的单 IP 限速桶，避免内存无限增长
+func startIdleBucketEviction() {
+	if idleTTL <= 0 {
+		return
+	}
+	ttl := time.Duration(idleTTL) * time.Second
+	go func() {
+		ticker := time.NewTicker(ttl)
+		defer ticker.Stop()
+		for range ticker.C {
+			now := time.Now()
+			ipBuckets.Range(func(key, value interface{}) bool {
+				if now.Sub(value.(*ipBucketEntry).lastUsed) > ttl {
+					ipBuckets.Delete(key)
+				}
+				return true
+			})
+		}
+	}()
+}
+
+// rpsLimitMiddleware 对重定向路由做请求级限流，超出速率时返回 429
+func rpsLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if rpsBucket != nil && rpsBucket.TakeAvailable(1) == 0 {
+			c.AbortWithStatus(http.StatusTooManyRequests)
+			return
+		}
+		c.Next()
+	}
+}
+
+// requestCounterMiddleware 记录某条路由被访问的次数，计入 /stats 与 /metrics
+func requestCounterMiddleware(route string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stats.recordRequest(route)
+		c.Next()
+	}
+}
+
+// countingWriter 包装 io.Writer，将写入的字节数计入 Stats.bytesProxied
+type countingWriter struct {
+	w io.Writer
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	stats.addBytesProxied(int64(n))
+	return n, err
+}
+
+// findFastestDomainSet 从文件中读取域名列表并进行测速，按与 "all" 集合一致的
+// 阈值过滤和加权得分排序后返回，使 multi/single 也受 -sl/-tl/-tll 和得分的约束。
+// 读取文件失败时返回 error，交由调用方决定如何降级，而不是在此终止进程。
+func findFastestDomainSet(filename string) ([]Domain, error) {
+	domains, err := readDomainsFromFile(filename)
+	if err != nil {
+		return nil, err
+	}
 	measureLatencyAndDownload(&domains)
-	sort.Sort(ByLatency(domains))
-	return domains[0].Name
+	updateDomainsCert(&domains)
+
+	filtered := make([]Domain, 0, len(domains))
+	for _, d := range domains {
+		if passesThresholds(d) {
+			filtered = append(filtered, d)
+		}
+	}
+	if len(filtered) == 0 && len(domains) > 0 {
+		logger.Warn("没有节点满足延迟/速度阈值，回退为未过滤的测速结果", zap.String("file", filename))
+		filtered = domains
+	}
+	sortDomainsByScore(filtered)
+	return filtered, nil
 }
 
 // 提取原始域名和路径
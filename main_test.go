@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+func TestSortDomainsByScoreOrdersByWeightedNormalizedScore(t *testing.T) {
+	domains := []Domain{
+		{Name: "slow-fast", Latency: 200, Download: 10000},
+		{Name: "fast-slow", Latency: 10, Download: 100},
+		{Name: "fast-fast", Latency: 10, Download: 10000},
+	}
+
+	sortDomainsByScore(domains)
+
+	if domains[0].Name != "fast-fast" {
+		t.Fatalf("expected fast-fast to rank first, got %s", domains[0].Name)
+	}
+	if domains[len(domains)-1].Name == "fast-fast" {
+		t.Fatalf("fast-fast should not rank last")
+	}
+}
+
+func TestSortDomainsByScoreHandlesUniformValues(t *testing.T) {
+	domains := []Domain{
+		{Name: "a", Latency: 50, Download: 5000},
+		{Name: "b", Latency: 50, Download: 5000},
+	}
+
+	sortDomainsByScore(domains)
+
+	if len(domains) != 2 {
+		t.Fatalf("expected 2 domains, got %d", len(domains))
+	}
+}
+
+func TestPassesThresholdsRejectsFailedOrTimedOutDomains(t *testing.T) {
+	if passesThresholds(Domain{DownloadErr: true}) {
+		t.Fatal("expected DownloadErr domain to be rejected")
+	}
+	if passesThresholds(Domain{Latency: -1}) {
+		t.Fatal("expected Latency == -1 domain to be rejected")
+	}
+}
+
+func TestPassesThresholdsAppliesLatencyAndSpeedBounds(t *testing.T) {
+	origMax, origMin, origSpeed := maxLatencyMs, minLatencyMs, minSpeedMBs
+	defer func() {
+		maxLatencyMs, minLatencyMs, minSpeedMBs = origMax, origMin, origSpeed
+	}()
+
+	maxLatencyMs, minLatencyMs, minSpeedMBs = 100, 10, 1
+
+	cases := []struct {
+		name string
+		d    Domain
+		want bool
+	}{
+		{"within bounds", Domain{Latency: 50, Download: 2048}, true},
+		{"latency too high", Domain{Latency: 150, Download: 2048}, false},
+		{"latency too low", Domain{Latency: 5, Download: 2048}, false},
+		{"speed too low", Domain{Latency: 50, Download: 512}, false},
+	}
+	for _, tc := range cases {
+		if got := passesThresholds(tc.d); got != tc.want {
+			t.Errorf("%s: passesThresholds() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+// Package mget 实现一个简易的多连接分段下载器，用于加速单个大文件的拉取。
+package mget
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Segment 描述一个字节区间分段及其完成状态
+type Segment struct {
+	Index int   `json:"index"`
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Done  bool  `json:"done"`
+}
+
+// Progress 是持久化到 .mget.json 的分段进度，用于断点续传
+type Progress struct {
+	URL      string    `json:"url"`
+	Size     int64     `json:"size"`
+	Segments []Segment `json:"segments"`
+}
+
+// Downloader 负责探测上游、拆分分段、并发拉取并重新拼装成完整文件
+type Downloader struct {
+	URL        string
+	Segments   int
+	MaxRetries int
+	WorkDir    string
+	Client     *http.Client
+}
+
+// NewDownloader 创建一个 Downloader，workDir 用于存放分段文件和 .mget.json 进度文件
+func NewDownloader(url string, segments, maxRetries int, workDir string) *Downloader {
+	return &Downloader{
+		URL:        url,
+		Segments:   segments,
+		MaxRetries: maxRetries,
+		WorkDir:    workDir,
+		Client:     &http.Client{},
+	}
+}
+
+// Size 探测上游文件大小，供调用方设置 Content-Length
+func (d *Downloader) Size(ctx context.Context) (int64, error) {
+	size, _, err := d.head(ctx)
+	return size, err
+}
+
+// head 确认上游是否支持 Range 请求并返回文件总大小
+func (d *Downloader) head(ctx context.Context) (int64, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, d.URL, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+	return resp.ContentLength, resp.Header.Get("Accept-Ranges") == "bytes", nil
+}
+
+// workDirLocks 序列化对同一个工作目录（按 URL 哈希得到，与 WorkDir 一一对应）的并发访问，
+// 避免两个同时请求同一文件的客户端互相覆盖对方的分段文件和 .mget.json
+var workDirLocks sync.Map // map[string]*sync.Mutex
+
+func lockWorkDir(workDir string) *sync.Mutex {
+	v, _ := workDirLocks.LoadOrStore(workDir, &sync.Mutex{})
+	return v.(*sync.Mutex)
+}
+
+// Download 拉取上游文件并按顺序写入 w；当上游不支持 Range 时退化为单连接直传。
+// 多分段下载时，每个分段一落盘就立即流式写入 w，不等待整个文件下载完成。
+func (d *Downloader) Download(ctx context.Context, w io.Writer) error {
+	size, supportsRange, err := d.head(ctx)
+	if err != nil {
+		return err
+	}
+	if !supportsRange || size <= 0 || d.Segments <= 1 {
+		return d.downloadSingleStream(ctx, w)
+	}
+
+	// 同一 WorkDir（即同一上游 URL）的并发请求在此排队，防止互相踩踏分段文件；
+	// 若前一个请求失败，后一个请求会复用其 .mget.json 进度继续未完成的分段。
+	mu := lockWorkDir(d.WorkDir)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := os.MkdirAll(d.WorkDir, 0o755); err != nil {
+		return err
+	}
+
+	progress, err := d.loadProgress()
+	if err != nil || progress.URL != d.URL || progress.Size != size {
+		progress = &Progress{URL: d.URL, Size: size, Segments: buildSegments(size, d.Segments)}
+	}
+
+	var progressMu sync.Mutex
+	done := make([]chan error, len(progress.Segments))
+	for i := range progress.Segments {
+		done[i] = make(chan error, 1)
+		if progress.Segments[i].Done {
+			done[i] <- nil
+			continue
+		}
+		go func(idx int) {
+			seg := progress.Segments[idx]
+			err := d.fetchSegmentWithRetry(ctx, seg)
+			if err == nil {
+				progressMu.Lock()
+				progress.Segments[idx].Done = true
+				_ = d.saveProgress(progress)
+				progressMu.Unlock()
+			}
+			done[idx] <- err
+		}(i)
+	}
+
+	// 按顺序等待每个分段就绪后立即写出，而不是等全部分段都下载完再拼接
+	for i, seg := range progress.Segments {
+		if err := <-done[i]; err != nil {
+			return err
+		}
+		if err := d.streamSegment(seg, w); err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(d.WorkDir)
+}
+
+func (d *Downloader) streamSegment(seg Segment, w io.Writer) error {
+	f, err := os.Open(d.segmentPath(seg))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (d *Downloader) downloadSingleStream(ctx context.Context, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (d *Downloader) fetchSegmentWithRetry(ctx context.Context, seg Segment) error {
+	var lastErr error
+	backoff := 500 * time.Millisecond
+	for attempt := 0; attempt <= d.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err := d.fetchSegment(ctx, seg); err != nil {
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("分段 %d 重试 %d 次后仍然失败: %w", seg.Index, d.MaxRetries, lastErr)
+}
+
+func (d *Downloader) fetchSegment(ctx context.Context, seg Segment) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", seg.Start, seg.End))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("分段 %d 请求未返回 206: %d", seg.Index, resp.StatusCode)
+	}
+
+	f, err := os.Create(d.segmentPath(seg))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, resp.Body)
+	return err
+}
+
+func (d *Downloader) progressPath() string {
+	return filepath.Join(d.WorkDir, ".mget.json")
+}
+
+func (d *Downloader) segmentPath(seg Segment) string {
+	return filepath.Join(d.WorkDir, fmt.Sprintf("segment-%d", seg.Index))
+}
+
+func (d *Downloader) loadProgress() (*Progress, error) {
+	data, err := os.ReadFile(d.progressPath())
+	if err != nil {
+		return nil, err
+	}
+	var p Progress
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (d *Downloader) saveProgress(p *Progress) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(d.progressPath(), data, 0o644)
+}
+
+// SweepStaleWorkDirs 清理 baseDir 下修改时间早于 maxAge 的工作目录，用于回收
+// 失败或中途放弃的下载遗留的分段文件和 .mget.json，避免磁盘占用无限增长。
+// 以目录及其中文件的最新修改时间为准：仍在进行中的下载会持续写入分段文件、刷新其修改时间，因此不会被误删。
+func SweepStaleWorkDirs(baseDir string, maxAge time.Duration) error {
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		// 目录自身的修改时间只在增删文件时变化，因此同时取其中文件的最新修改时间
+		dir := filepath.Join(baseDir, entry.Name())
+		latest := info.ModTime()
+		if files, err := os.ReadDir(dir); err == nil {
+			for _, f := range files {
+				if fi, err := f.Info(); err == nil && fi.ModTime().After(latest) {
+					latest = fi.ModTime()
+				}
+			}
+		}
+		if now.Sub(latest) > maxAge {
+			_ = os.RemoveAll(dir)
+		}
+	}
+	return nil
+}
+
+// StartStaleWorkDirSweeper 启动一个后台协程，按 interval 周期性清理 baseDir 下
+// 超过 maxAge 未被使用的工作目录
+func StartStaleWorkDirSweeper(baseDir string, maxAge, interval time.Duration) {
+	if maxAge <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			_ = SweepStaleWorkDirs(baseDir, maxAge)
+		}
+	}()
+}
+
+func buildSegments(size int64, n int) []Segment {
+	if n <= 0 {
+		n = 1
+	}
+	segSize := size / int64(n)
+	segments := make([]Segment, 0, n)
+	var start int64
+	for i := 0; i < n; i++ {
+		end := start + segSize - 1
+		if i == n-1 {
+			end = size - 1
+		}
+		segments = append(segments, Segment{Index: i, Start: start, End: end})
+		start = end + 1
+	}
+	return segments
+}
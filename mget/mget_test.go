@@ -0,0 +1,44 @@
+package mget
+
+import "testing"
+
+func TestBuildSegmentsEvenlyDividesSize(t *testing.T) {
+	segments := buildSegments(100, 4)
+
+	if len(segments) != 4 {
+		t.Fatalf("expected 4 segments, got %d", len(segments))
+	}
+	if segments[0].Start != 0 {
+		t.Fatalf("expected first segment to start at 0, got %d", segments[0].Start)
+	}
+	if segments[len(segments)-1].End != 99 {
+		t.Fatalf("expected last segment to end at size-1 (99), got %d", segments[len(segments)-1].End)
+	}
+	for i := 1; i < len(segments); i++ {
+		if segments[i].Start != segments[i-1].End+1 {
+			t.Fatalf("segment %d does not continue from segment %d: %d != %d+1", i, i-1, segments[i].Start, segments[i-1].End)
+		}
+	}
+}
+
+func TestBuildSegmentsRemainderGoesToLastSegment(t *testing.T) {
+	segments := buildSegments(10, 3)
+
+	if len(segments) != 3 {
+		t.Fatalf("expected 3 segments, got %d", len(segments))
+	}
+	if segments[len(segments)-1].End != 9 {
+		t.Fatalf("expected last segment to cover up to size-1 (9), got %d", segments[len(segments)-1].End)
+	}
+}
+
+func TestBuildSegmentsTreatsNonPositiveCountAsOne(t *testing.T) {
+	segments := buildSegments(50, 0)
+
+	if len(segments) != 1 {
+		t.Fatalf("expected 1 segment, got %d", len(segments))
+	}
+	if segments[0].Start != 0 || segments[0].End != 49 {
+		t.Fatalf("expected single segment to cover [0,49], got [%d,%d]", segments[0].Start, segments[0].End)
+	}
+}
@@ -0,0 +1,199 @@
+// Package mirror 提供可插拔的镜像域名数据源，替代写死的文本文件依赖。
+package mirror
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// MirrorSource 是一个域名来源：每次 Fetch 返回当前可用的主机名列表
+type MirrorSource interface {
+	Fetch(ctx context.Context) ([]string, error)
+}
+
+// FileSource 从本地文本文件按行读取域名，对应历史上的硬编码行为
+type FileSource struct {
+	Path string
+}
+
+func (s FileSource) Fetch(ctx context.Context) ([]string, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return scanHosts(f)
+}
+
+// HTTPSource 通过 GET 请求从远程地址获取域名列表，响应体可以是 JSON 字符串数组或纯文本行
+type HTTPSource struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s HTTPSource) Fetch(ctx context.Context) ([]string, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	if err := json.Unmarshal(body, &hosts); err == nil {
+		return hosts, nil
+	}
+	return scanHosts(strings.NewReader(string(body)))
+}
+
+var sfMirrorHostPattern = regexp.MustCompile(`[a-z0-9-]+\.dl\.sourceforge\.net`)
+
+// SourceForgeAPISource 查询 SourceForge 某个项目的镜像选择页面，提取其中的镜像主机名
+type SourceForgeAPISource struct {
+	Project string
+	Client  *http.Client
+}
+
+func (s SourceForgeAPISource) Fetch(ctx context.Context) ([]string, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	endpoint := fmt.Sprintf("https://sourceforge.net/settings/mirror_choices?projectname=%s&formatted=true", url.QueryEscape(s.Project))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	var hosts []string
+	for _, m := range sfMirrorHostPattern.FindAllString(string(body), -1) {
+		if _, ok := seen[m]; ok {
+			continue
+		}
+		seen[m] = struct{}{}
+		hosts = append(hosts, m)
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("未能从 SourceForge 镜像选择页面解析出任何域名")
+	}
+	return hosts, nil
+}
+
+func scanHosts(r io.Reader) ([]string, error) {
+	var hosts []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			hosts = append(hosts, line)
+		}
+	}
+	return hosts, scanner.Err()
+}
+
+// ParseSources 按逗号拆分 -source 取值，根据协议前缀构建对应的 MirrorSource：
+// file://<path>、http(s)://<url>、sf://<project>。
+//
+// dns://<pattern>@<server> 特意不受支持：公共 DNS 协议本身不支持枚举一个区域下的
+// 全部 A 记录（需要区域传送 AXFR，权威服务器通常默认拒绝），任何"解析通配符"的实现
+// 要么是只返回基础域名的假数据，要么需要额外的区域传送权限，因此这里直接报错，
+// 而不是伪装成一个能枚举镜像的数据源。
+func ParseSources(spec string) ([]MirrorSource, error) {
+	var sources []MirrorSource
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(part, "file://"):
+			sources = append(sources, FileSource{Path: strings.TrimPrefix(part, "file://")})
+		case strings.HasPrefix(part, "http://"), strings.HasPrefix(part, "https://"):
+			sources = append(sources, HTTPSource{URL: part})
+		case strings.HasPrefix(part, "sf://"):
+			sources = append(sources, SourceForgeAPISource{Project: strings.TrimPrefix(part, "sf://")})
+		case strings.HasPrefix(part, "dns://"):
+			return nil, fmt.Errorf("dns:// 数据源暂不支持：标准 DNS 协议无法枚举通配符域名下的全部主机名（需要区域传送 AXFR）: %s", part)
+		default:
+			return nil, fmt.Errorf("无法识别的镜像数据源: %s", part)
+		}
+	}
+	return sources, nil
+}
+
+// FetchAll 并发执行所有数据源，按主机名去重后合并为一份列表。
+// 单个数据源失败不影响其他数据源，只有全部失败时才返回错误。
+func FetchAll(ctx context.Context, sources []MirrorSource) ([]string, error) {
+	type result struct {
+		hosts []string
+		err   error
+	}
+	results := make([]result, len(sources))
+
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func(i int, src MirrorSource) {
+			defer wg.Done()
+			hosts, err := src.Fetch(ctx)
+			results[i] = result{hosts: hosts, err: err}
+		}(i, src)
+	}
+	wg.Wait()
+
+	seen := make(map[string]struct{})
+	var merged []string
+	var errs []string
+	for _, r := range results {
+		if r.err != nil {
+			errs = append(errs, r.err.Error())
+			continue
+		}
+		for _, h := range r.hosts {
+			if _, ok := seen[h]; ok {
+				continue
+			}
+			seen[h] = struct{}{}
+			merged = append(merged, h)
+		}
+	}
+
+	if len(errs) > 0 && len(errs) == len(sources) {
+		return nil, fmt.Errorf("所有镜像数据源均获取失败: %s", strings.Join(errs, "; "))
+	}
+	return merged, nil
+}
@@ -0,0 +1,88 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestParseSourcesBuildsOneSourcePerPrefix(t *testing.T) {
+	sources, err := ParseSources("file://all.txt, https://example.com/mirrors, sf://sevenzip")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sources) != 3 {
+		t.Fatalf("expected 3 sources, got %d", len(sources))
+	}
+	if _, ok := sources[0].(FileSource); !ok {
+		t.Errorf("expected sources[0] to be FileSource, got %T", sources[0])
+	}
+	if _, ok := sources[1].(HTTPSource); !ok {
+		t.Errorf("expected sources[1] to be HTTPSource, got %T", sources[1])
+	}
+	if _, ok := sources[2].(SourceForgeAPISource); !ok {
+		t.Errorf("expected sources[2] to be SourceForgeAPISource, got %T", sources[2])
+	}
+}
+
+func TestParseSourcesRejectsDNS(t *testing.T) {
+	if _, err := ParseSources("dns://*.dl.sourceforge.net@8.8.8.8"); err == nil {
+		t.Fatal("expected dns:// source to be rejected")
+	}
+}
+
+func TestParseSourcesRejectsUnknownPrefix(t *testing.T) {
+	if _, err := ParseSources("ftp://example.com"); err == nil {
+		t.Fatal("expected unrecognized source prefix to be rejected")
+	}
+}
+
+type fakeSource struct {
+	hosts []string
+	err   error
+}
+
+func (s fakeSource) Fetch(ctx context.Context) ([]string, error) {
+	return s.hosts, s.err
+}
+
+func TestFetchAllDedupesAcrossSources(t *testing.T) {
+	sources := []MirrorSource{
+		fakeSource{hosts: []string{"a.dl.sourceforge.net", "b.dl.sourceforge.net"}},
+		fakeSource{hosts: []string{"b.dl.sourceforge.net", "c.dl.sourceforge.net"}},
+	}
+
+	hosts, err := FetchAll(context.Background(), sources)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(hosts) != 3 {
+		t.Fatalf("expected 3 deduped hosts, got %d: %v", len(hosts), hosts)
+	}
+}
+
+func TestFetchAllToleratesPartialFailure(t *testing.T) {
+	sources := []MirrorSource{
+		fakeSource{err: errors.New("boom")},
+		fakeSource{hosts: []string{"a.dl.sourceforge.net"}},
+	}
+
+	hosts, err := FetchAll(context.Background(), sources)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(hosts) != 1 || hosts[0] != "a.dl.sourceforge.net" {
+		t.Fatalf("expected the surviving source's host, got %v", hosts)
+	}
+}
+
+func TestFetchAllErrorsWhenAllSourcesFail(t *testing.T) {
+	sources := []MirrorSource{
+		fakeSource{err: errors.New("boom1")},
+		fakeSource{err: errors.New("boom2")},
+	}
+
+	if _, err := FetchAll(context.Background(), sources); err == nil {
+		t.Fatal("expected error when all sources fail")
+	}
+}